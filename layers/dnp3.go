@@ -11,10 +11,10 @@ package layers
 import (
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/google/gopacket"
 )
@@ -177,6 +177,8 @@ var ObjRangeSpecifierCodes = map[byte]string{
 
 var (
 	errDNP3PacketTooShort = errors.New("DNS packet too short")
+	errDNP3HeaderCRC      = errors.New("DNP3 header CRC mismatch")
+	errDNP3BlockCRC       = errors.New("DNP3 user-data block CRC mismatch")
 )
 
 type DNP3 struct {
@@ -184,9 +186,28 @@ type DNP3 struct {
 	DNP3DataLinkLayer    DNP3DataLinkLayer
 	DNP3TransportLayer   DNP3TransportLayer
 	DNP3ApplicationLayer DNP3ApplicationLayer
-	SomeByte             byte
-	AnotherByte          byte
-	restOfData           []byte
+	// LenientCRC makes DecodeFromBytes record a CRC mismatch via
+	// df.SetTruncated() instead of returning an error, for callers that
+	// want to inspect frames from noisy or partially-captured links.
+	LenientCRC bool
+	// ApplicationPayload holds the fully reassembled application fragment
+	// last passed to DecodeApplicationPayload. decodeDNP3 does not populate
+	// it automatically: a caller that wants whole application PDUs out of a
+	// live capture pairs DecodeFromBytes with its own DNP3Reassembler and
+	// calls DecodeApplicationPayload once Accept reports a fragment
+	// complete.
+	ApplicationPayload []byte
+	SomeByte           byte
+	AnotherByte        byte
+	restOfData         []byte
+	// appObjectBytes caches the raw, not-yet-dissected application object
+	// bytes from DecodeFromBytes so SerializeTo can round-trip a frame even
+	// when DNP3ApplicationLayer.Objects hasn't been populated by hand.
+	appObjectBytes []byte
+	// blockCRCs caches the originally decoded per-block CRCs, in block
+	// order, so SerializeTo can replay them verbatim when
+	// opts.ComputeChecksums is false instead of leaving them zeroed.
+	blockCRCs []uint16
 }
 
 type DNP3DataLinkLayer struct {
@@ -199,6 +220,7 @@ type DNP3DataLinkLayer struct {
 		FCB         int    `json:"Frame Count Bit"`
 		FCV         int    `json:"Frame Count Valid"`
 		FUNC        string `json:"Function Code"`
+		FuncCode    byte   `json:"-"`
 	}
 	Destination int
 	Source      int
@@ -221,18 +243,50 @@ type DNP3ApplicationLayer struct {
 		Unsolicited int
 		Sequence    int
 	}
-	Function string `json:"Function Code"`
-	IINCode  string `json:"Internal Indication (IIN)"`
+	Function     string `json:"Function Code"`
+	FunctionCode byte   `json:"-"`
+	IINCode      string `json:"Internal Indication (IIN)"`
+	IIN          uint16 `json:"-"`
+	Objects      []DNP3AppObject
 }
 
 type DNP3AppObject struct {
-	Group      int
-	Variation  int
-	Qualifier  int
+	Group     int
+	Variation int
+	Qualifier int
+	// DataType is the object qualifier's range specifier code (RSC); see
+	// ObjRangeSpecifierCodes.
+	DataType int
+	// RangeStart and RangeStop are only meaningful for the start/stop-index
+	// range specifier codes (RSC 0-5); for the count-based codes (RSC 6-11)
+	// item indices instead come from each DNP3ObjectItem's Index.
 	RangeStart int
 	RangeStop  int
-	DataType   int
-	Length     int
+	// Length is the number of items this object block describes.
+	Length int
+	// Items holds one decoded entry per point in the object block, in wire
+	// order.
+	Items []DNP3ObjectItem
+	// RawData holds the group/variation/qualifier/range/point bytes for this
+	// object block exactly as they appeared on the wire, so SerializeTo can always
+	// reproduce the frame even for objects Items doesn't fully capture.
+	RawData []byte
+}
+
+// DNP3ObjectItem is one decoded point within a DNP3AppObject.
+type DNP3ObjectItem struct {
+	// Index is the point index (from the range field for RSC 0-5, or an
+	// index prefix (prefix codes 1-3), or an implicit 0-based count for
+	// RSC 6-11).
+	Index int
+	// Value holds the typed decoding of Raw for recognized (group,
+	// variation) pairs; see decodeDNP3ItemValue for the supported types.
+	// It is nil for groups/variations this package doesn't dissect yet.
+	Value interface{}
+	// Raw is the item's point data, without its index/size prefix, exactly
+	// as it appeared on the wire; for packed g1v1 points it is the byte
+	// holding the point's bit.
+	Raw []byte
 }
 
 func (d *DNP3) LayerType() gopacket.LayerType { return LayerTypeDNP3 }
@@ -248,18 +302,103 @@ func (d *DNP3) LayerPayload() []byte {
 func (d *DNP3) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 
 	// If the data block is too short to be a DNP3 layer, then return an error.
-	if len(data) < 10 {
+	if len(data) < MIN_HEADER_LENGTH {
 		df.SetTruncated()
 		return errDNP3PacketTooShort
 	}
 
 	d.linkLayer(data)
-	d.transportLayer(data)
-	d.applicationLayer(data)
+
+	payload, err := d.verifyAndStripCRCs(data, df)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) == 0 {
+		// Link-layer control frames (ACK, NAK, link status, reset of
+		// remote/user link, ...) carry no user data at all, so there's no
+		// transport byte or application header to decode.
+		return nil
+	}
+
+	d.transportLayer(payload)
+	// restOfData is this frame's share of the application fragment, i.e.
+	// the payload with the transport byte removed. For a fragment spread
+	// across several link frames it's only a slice of the fragment;
+	// DNP3Reassembler stitches those slices back together keyed by
+	// sequence number.
+	d.restOfData = payload[1:]
+	d.DNP3ApplicationLayer = DNP3ApplicationLayer{}
+	d.appObjectBytes = nil
+	if d.DNP3TransportLayer.First == 1 {
+		// Only a First segment starts with an application header; a
+		// continuation segment is pure object data with no header of its
+		// own, so parsing it as one would read garbage as the control
+		// byte/function code. DNP3ApplicationLayer is left zeroed for
+		// continuation segments; callers that need the fragment's real
+		// Objects call DecodeApplicationPayload once reassembly completes.
+		d.applicationLayer(d.restOfData)
+	}
 
 	return nil
 }
 
+// verifyAndStripCRCs checks the header CRC (the two bytes following the
+// 8-byte link header) and the trailing CRC on every subsequent 16-octet
+// user-data block, returning the transport+application bytes with all CRCs
+// removed so downstream decoders see a contiguous logical payload. On a
+// mismatch it calls df.SetTruncated() and, unless LenientCRC is set,
+// returns an error instead of a payload.
+func (d *DNP3) verifyAndStripCRCs(data []byte, df gopacket.DecodeFeedback) ([]byte, error) {
+	if crcDNP3(data[0:8]) != binary.LittleEndian.Uint16(data[8:10]) {
+		df.SetTruncated()
+		if !d.LenientCRC {
+			return nil, errDNP3HeaderCRC
+		}
+	}
+
+	rest := data[MIN_HEADER_LENGTH:]
+	payload := make([]byte, 0, len(rest))
+	d.blockCRCs = d.blockCRCs[:0]
+	for len(rest) > 2 {
+		n := dnp3BlockSize
+		if len(rest)-2 < n {
+			n = len(rest) - 2
+		}
+
+		block := rest[:n]
+		blockCRC := binary.LittleEndian.Uint16(rest[n : n+2])
+		if crcDNP3(block) != blockCRC {
+			df.SetTruncated()
+			if !d.LenientCRC {
+				return nil, errDNP3BlockCRC
+			}
+		}
+
+		payload = append(payload, block...)
+		d.blockCRCs = append(d.blockCRCs, blockCRC)
+		rest = rest[n+2:]
+	}
+
+	return payload, nil
+}
+
+// DNP3Port is the IANA-assigned TCP/UDP port DNP3 is normally carried on.
+const DNP3Port = 20000
+
+func init() {
+	RegisterTCPPortLayerType(TCPPort(DNP3Port), LayerTypeDNP3)
+	RegisterUDPPortLayerType(UDPPort(DNP3Port), LayerTypeDNP3)
+}
+
+// decodeDNP3 decodes one DNP3 link frame in isolation: it does not reassemble
+// multi-frame application fragments, since doing so automatically would mean
+// sharing one DNP3Reassembler across every capture the process decodes and
+// merging fragments from unrelated captures that reuse the same DNP3
+// addresses (see DNP3Reassembler's doc comment). Callers who want whole
+// application PDUs should construct their own DNP3Reassembler, feed it each
+// decoded layer's Accept call, and call DecodeApplicationPayload once a
+// fragment completes — the way tcpassembly/dnp3assembly does per TCP stream.
 func decodeDNP3(data []byte, p gopacket.PacketBuilder) error {
 
 	// Attempt to decode the byte slice.
@@ -295,41 +434,224 @@ func (d *DNP3) Payload() []byte {
 	return nil
 }
 
-func appObject(bytesRead []byte) {
+// dnp3BlockSize is the number of user-data octets DNP3 CRCs over: the
+// header CRC covers the 8-byte link header and every subsequent block of up
+// to 16 transport/application octets gets its own trailing CRC.
+const dnp3BlockSize = 16
+
+// SerializeTo implements gopacket.SerializableLayer, rebuilding the data-link
+// header, transport byte and application header/objects from the decoded
+// fields. Every user-data block carries its 2-octet trailing CRC slot
+// regardless of opts, since that's part of DNP3's wire layout, not an
+// optional extra. When opts.FixLengths is set the link-layer length byte is
+// recomputed from the assembled user data; when opts.ComputeChecksums is set
+// a DNP3 CRC-16 is computed and inserted after the header and after every
+// 16-octet user-data block, otherwise the previously decoded header and
+// block CRCs are replayed verbatim (zeroed for any block that wasn't
+// produced by a decode, e.g. one added past the originally decoded blocks).
+// A transport byte is always written, so a link-layer frame that carries no
+// user data (such as an ACK or a link status frame) is not reproduced.
+func (d *DNP3) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	appData, err := d.serializeApplicationLayer()
+	if err != nil {
+		return err
+	}
 
-	object := bytesRead[22:]
+	userData := make([]byte, 0, 1+len(appData))
+	userData = append(userData, d.serializeTransportByte())
+	userData = append(userData, appData...)
 
-	// indexSize := uint(object[2] & 0x70 >> 4)
-	// QualifierCode := uint(object[2] & 0x0F)
-	// fmt.Println(indexSize)
-	// fmt.Println(QualifierCode)
+	if opts.FixLengths {
+		d.DNP3DataLinkLayer.Length = 5 + len(userData)
+	}
 
-	group := int(object[0])
-	variation := int(object[1])
-	qualifier := int(object[2])
-	rangeStart := int(object[3])
-	rangeStop := int(object[4])
-	dataType := int(object[5])
-	length := int(object[6])
+	blocks := splitIntoBlocks(userData, dnp3BlockSize)
 
-	appObject := DNP3AppObject{
-		Group:      group,
-		Variation:  variation,
-		Qualifier:  qualifier,
-		RangeStart: rangeStart,
-		RangeStop:  rangeStop,
-		DataType:   dataType,
-		Length:     length,
+	frameLen := MIN_HEADER_LENGTH
+	for _, block := range blocks {
+		frameLen += len(block) + 2
 	}
 
-	out, err := json.Marshal(appObject)
+	buf, err := b.PrependBytes(frameLen)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	binary.BigEndian.PutUint16(buf[0:2], START_FIELD)
+	buf[2] = byte(d.DNP3DataLinkLayer.Length)
+	buf[3] = d.serializeLinkControlByte()
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(d.DNP3DataLinkLayer.Destination))
+	binary.LittleEndian.PutUint16(buf[6:8], uint16(d.DNP3DataLinkLayer.Source))
+
+	if opts.ComputeChecksums {
+		binary.LittleEndian.PutUint16(buf[8:10], crcDNP3(buf[0:8]))
+	} else {
+		binary.LittleEndian.PutUint16(buf[8:10], d.linkCRC())
+	}
+
+	offset := MIN_HEADER_LENGTH
+	for i, block := range blocks {
+		offset += copy(buf[offset:], block)
+
+		var blockCRC uint16
+		switch {
+		case opts.ComputeChecksums:
+			blockCRC = crcDNP3(block)
+		case i < len(d.blockCRCs):
+			blockCRC = d.blockCRCs[i]
+		}
+		binary.LittleEndian.PutUint16(buf[offset:offset+2], blockCRC)
+		offset += 2
+	}
+
+	return nil
+}
+
+// linkCRC returns the header CRC last recorded in DNP3DataLinkLayer.CRC, so
+// SerializeTo can preserve it when opts.ComputeChecksums is false.
+func (d *DNP3) linkCRC() uint16 {
+	v, _ := strconv.ParseUint(strings.TrimPrefix(d.DNP3DataLinkLayer.CRC, "0x"), 16, 16)
+	return uint16(v)
+}
+
+// serializeLinkControlByte rebuilds the data-link control byte from its
+// decoded fields.
+func (d *DNP3) serializeLinkControlByte() byte {
+	c := d.DNP3DataLinkLayer.Control
+	var out byte
+	out |= byte(c.IsMaster&0x1) << 7
+	out |= byte(c.PRM&0x1) << 6
+	out |= byte(c.FCB&0x1) << 5
+	out |= byte(c.FCV&0x1) << 4
+	out |= c.FuncCode & 0x0f
+	return out
+}
+
+// serializeTransportByte rebuilds the transport-layer byte from its decoded
+// fields.
+func (d *DNP3) serializeTransportByte() byte {
+	t := d.DNP3TransportLayer
+	var out byte
+	out |= byte(t.Final&0x1) << 7
+	out |= byte(t.First&0x1) << 6
+	out |= byte(t.Sequence & 0x3f)
+	return out
+}
+
+// serializeAppControlByte rebuilds the application-layer control byte from
+// its decoded fields.
+func (d *DNP3) serializeAppControlByte() byte {
+	c := d.DNP3ApplicationLayer.Control
+	var out byte
+	out |= byte(c.First&0x1) << 7
+	out |= byte(c.Final&0x1) << 6
+	out |= byte(c.Confirm&0x1) << 5
+	out |= byte(c.Unsolicited&0x1) << 4
+	out |= byte(c.Sequence & 0x0f)
+	return out
+}
+
+// serializeApplicationLayer rebuilds the application header (control byte,
+// function code and, for responses, the IIN) followed by the object blocks.
+// If DNP3ApplicationLayer.Objects has been populated it is serialized,
+// otherwise the raw object bytes captured by DecodeFromBytes are reused
+// verbatim so a decoded-then-reserialized frame matches byte-for-byte. A
+// continuation segment (First == 0) with no Objects has no application
+// header of its own, so its decoded share of the fragment is replayed as is.
+func (d *DNP3) serializeApplicationLayer() ([]byte, error) {
+	if d.DNP3TransportLayer.First == 0 && len(d.DNP3ApplicationLayer.Objects) == 0 {
+		return d.restOfData, nil
+	}
+
+	isResponse := d.DNP3DataLinkLayer.Control.IsMaster == 0
+
+	objBytes := d.appObjectBytes
+	if len(d.DNP3ApplicationLayer.Objects) > 0 {
+		var err error
+		objBytes, err = serializeAppObjects(d.DNP3ApplicationLayer.Objects)
+		if err != nil {
+			return nil, err
+		}
 	}
-	fmt.Println(string(out))
 
+	out := make([]byte, 0, 4+len(objBytes))
+	out = append(out, d.serializeAppControlByte(), d.DNP3ApplicationLayer.FunctionCode)
+	if isResponse {
+		iin := make([]byte, 2)
+		binary.BigEndian.PutUint16(iin, d.DNP3ApplicationLayer.IIN)
+		out = append(out, iin...)
+	}
+	out = append(out, objBytes...)
+	return out, nil
+}
+
+// serializeAppObjects encodes a slice of application objects back into wire
+// format. RawData, when present, is emitted verbatim and reproduces the
+// object exactly as decoded. Objects built by hand for crafting a new
+// packet (no RawData) fall back to a group/variation/qualifier header with
+// an 8-bit start/stop range and each item's Raw bytes; that only covers the
+// RSC-0, unprefixed case, since reconstructing every qualifier/prefix
+// combination decodeDNP3Objects understands isn't needed to craft traffic.
+func serializeAppObjects(objects []DNP3AppObject) ([]byte, error) {
+	var out []byte
+	for _, o := range objects {
+		if len(o.RawData) > 0 {
+			out = append(out, o.RawData...)
+			continue
+		}
+
+		out = append(out, byte(o.Group), byte(o.Variation), byte(o.Qualifier))
+		out = append(out, byte(o.RangeStart), byte(o.RangeStop))
+		for _, item := range o.Items {
+			out = append(out, item.Raw...)
+		}
+	}
+	return out, nil
+}
+
+// splitIntoBlocks splits data into chunks of at most size bytes, as used to
+// lay out DNP3's per-block CRCs.
+func splitIntoBlocks(data []byte, size int) [][]byte {
+	var blocks [][]byte
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		blocks = append(blocks, data[:n])
+		data = data[n:]
+	}
+	return blocks
 }
 
+// crcDNP3 computes the 16-bit CRC DNP3 uses over the link header and every
+// 16-octet user-data block: polynomial 0x3D65 (reflected 0xA6BC), initial
+// value 0x0000, XOR-out 0xFFFF, transmitted little-endian on the wire.
+func crcDNP3(buf []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range buf {
+		crc = dnp3CRCTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc ^ 0xFFFF
+}
+
+var dnp3CRCTable = func() [256]uint16 {
+	const poly = 0xA6BC
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
 func (d *DNP3) linkLayer(data []byte) {
 
 	start := d.hexConvert(data[0:2])
@@ -354,6 +676,7 @@ func (d *DNP3) linkLayer(data []byte) {
 	d.DNP3DataLinkLayer.Control.FCV = FCV
 
 	FUNCCODE := data[3] & 0x0F
+	d.DNP3DataLinkLayer.Control.FuncCode = FUNCCODE
 	ctlFUNCCODE := fmt.Sprintf("%d", FUNCCODE)
 
 	var ctlFUNC string
@@ -368,15 +691,9 @@ func (d *DNP3) linkLayer(data []byte) {
 	ctlFUNC = ctlFUNC + " (" + ctlFUNCCODE + ")"
 	d.DNP3DataLinkLayer.Control.FUNC = ctlFUNC
 
-	// TODO: make sure 0 to 65535
-	destination := fmt.Sprintf("%x%x", data[5], data[4])
-	destinationInt, _ := strconv.Atoi(destination)
-	d.DNP3DataLinkLayer.Destination = destinationInt
+	d.DNP3DataLinkLayer.Destination = int(binary.LittleEndian.Uint16(data[4:6]))
 
-	// TODO: make sure 0 to 65535
-	source := fmt.Sprintf("%x%x", data[7], data[6])
-	sourceInt, _ := strconv.Atoi(source)
-	d.DNP3DataLinkLayer.Source = sourceInt
+	d.DNP3DataLinkLayer.Source = int(binary.LittleEndian.Uint16(data[6:8]))
 
 	// TODO: Is correct? Hesapla
 	crc := fmt.Sprintf("0x%x%x", data[9], data[8])
@@ -384,23 +701,38 @@ func (d *DNP3) linkLayer(data []byte) {
 
 }
 
-func (d *DNP3) transportLayer(data []byte) {
+// transportLayer parses the transport byte from the CRC-stripped logical
+// payload (payload[0] is the transport byte that followed the link header
+// on the wire).
+func (d *DNP3) transportLayer(payload []byte) {
 
-	transport := fmt.Sprintf("0x%x", data[10])
+	transport := fmt.Sprintf("0x%x", payload[0])
 	d.DNP3TransportLayer.TransportByte = transport
 
-	final := data[10] & 0x80 >> 7
+	final := payload[0] & 0x80 >> 7
 	d.DNP3TransportLayer.Final = int(final)
 
-	first := data[10] & 0x40 >> 6
+	first := payload[0] & 0x40 >> 6
 	d.DNP3TransportLayer.First = int(first)
 
-	sequence := data[10] & 0x3f // 6bit
+	sequence := payload[0] & 0x3f // 6bit
 	d.DNP3TransportLayer.Sequence = int(sequence)
 
 }
 
+// applicationLayer parses the application header and objects from data,
+// which must start at the application control byte (data[0] is the control
+// byte, data[1] the function code, and so on) — the shape of a First
+// transport segment's restOfData, and also of a fully reassembled fragment
+// passed to DecodeApplicationPayload. It returns early, leaving
+// DNP3ApplicationLayer zeroed, if data is too short to hold an application
+// header at all.
 func (d *DNP3) applicationLayer(data []byte) {
+	d.DNP3ApplicationLayer = DNP3ApplicationLayer{}
+	d.appObjectBytes = nil
+	if len(data) < 2 {
+		return
+	}
 
 	// 	/***************************************************************************/
 	// /* Application Layer Bit-Masks */
@@ -412,25 +744,26 @@ func (d *DNP3) applicationLayer(data []byte) {
 	// 	#define DNP3_AL_SEQ   0x0f
 	// 	#define DNP3_AL_FUNC  0xff
 
-	controlByte := fmt.Sprintf("0x%x", data[11])
+	controlByte := fmt.Sprintf("0x%x", data[0])
 	d.DNP3ApplicationLayer.Control.ControlByte = controlByte
 
-	first := data[11] & 0x80 >> 7
+	first := data[0] & 0x80 >> 7
 	d.DNP3ApplicationLayer.Control.First = int(first)
 
-	final := data[11] & 0x40 >> 6
+	final := data[0] & 0x40 >> 6
 	d.DNP3ApplicationLayer.Control.Final = int(final)
 
-	confirm := data[11] & 0x20 >> 5
+	confirm := data[0] & 0x20 >> 5
 	d.DNP3ApplicationLayer.Control.Confirm = int(confirm)
 
-	unsolicited := data[11] & 0x10 >> 4
+	unsolicited := data[0] & 0x10 >> 4
 	d.DNP3ApplicationLayer.Control.Unsolicited = int(unsolicited)
 
-	sequence := data[11] & 0x0f
+	sequence := data[0] & 0x0f
 	d.DNP3ApplicationLayer.Control.Sequence = int(sequence)
 
-	functionCode := data[12]
+	functionCode := data[1]
+	d.DNP3ApplicationLayer.FunctionCode = functionCode
 
 	// TODO: refactor this hex convert
 	src := []byte{functionCode}
@@ -442,12 +775,14 @@ func (d *DNP3) applicationLayer(data []byte) {
 	function := FCodes[functionCode] + " (" + FUNC + ")"
 	d.DNP3ApplicationLayer.Function = function
 
-	objectStart := 13
-	if d.DNP3DataLinkLayer.Control.IsMaster == 0 {
-		objectStart = 15
+	objectStart := 2
+	if d.DNP3DataLinkLayer.Control.IsMaster == 0 && len(data) >= 4 {
+		objectStart = 4
+
+		d.DNP3ApplicationLayer.IIN = binary.BigEndian.Uint16(data[2:4])
 
 		// TODO: refactor this hex convert
-		src := []byte{data[13], data[14]}
+		src := []byte{data[2], data[3]}
 		dst := make([]byte, hex.EncodedLen(len(src)))
 		hex.Encode(dst, src)
 		IIN := fmt.Sprintf("0x%s", dst)
@@ -455,178 +790,27 @@ func (d *DNP3) applicationLayer(data []byte) {
 		d.DNP3ApplicationLayer.IINCode = IINCode
 	}
 
-	dataSize := len(data[12:])
-	fmt.Printf("DataSize %d\n", dataSize)
-
-	switch functionCode {
-	case 0: // Confirm
-	case 1: // Read
-	case 2: // Write
-	case 3: // Select
-	case 4: // Operate
-	case 5: // Direct Operate
-	case 6: // Direct Operate No ACK
-	case 7: // Immediate Freeze
-	case 8: // Immediate Freeze No ACK
-	case 9: // Freeze and Clear
-	case 10: // Freeze and Clear No ACK
-	case 11: // Freeze With Time
-	case 12: // Freeze With Time No ACK
-	case 13: // Cold Restart
-	case 14: // Warm Restart
-	case 15: // Initialize Data
-	case 16: // Initialize Application
-	case 17: // Start Application
-	case 18: // Stop Application
-	case 19: // Save Configuration
-	case 20: // Enable Spontaneous Msg
-	case 21: // Disable Spontaneous Msg
-	case 22: // Assign Classes
-	case 23: // Delay Measurement
-	case 24: // Record Current Time
-	case 25: // Open File
-	case 26: // Close File
-	case 27: // Delete File
-	case 28: // Get File Info
-	case 29: // Authenticate File
-	case 30: // Abort File
-	case 31: // Activate Config
-	case 32: // Authentication Request
-	case 33: // Authentication Error
-	case 129: // Response
-	case 130: // Unsolicited Response
-	case 131: // Authentication Response
-	}
-
-	objTypeField := binary.BigEndian.Uint16([]byte{data[objectStart], data[objectStart+1]})
-	objectGroup := objTypeField & 0xFF00
-	objectVariation := objTypeField & 0x00FF
-	object := d.hexConvert([]byte{data[objectStart], data[objectStart+1]})
-	objectPrefixCode := data[objectStart+2] & 0x70         // OPC
-	objectRangeSpecifierCode := data[objectStart+2] & 0x0F // RSC
-	fmt.Println(object)
-	fmt.Println(objectGroup)
-	fmt.Println(objectVariation)
-	fmt.Printf("Prefix Code %d\n", objectPrefixCode)
-	fmt.Println(ObjPrefixCodes[objectPrefixCode])
-	fmt.Printf("Range Specifier Code %d\n", objectRangeSpecifierCode) // 6 means no range field
-	fmt.Println(ObjRangeSpecifierCodes[objectRangeSpecifierCode])
-	fmt.Println(d.hexConvert([]byte{data[objectStart+3]}))
-
-	offset := objectStart + 3
-	rangebytes := 0
-	fmt.Println(offset)
-	switch objectRangeSpecifierCode {
-	case 0:
-		// start := offset
-		numItems := int(data[offset+1]) - int(data[offset]) + 1
-		rangebytes = 2
-		fmt.Println(numItems)
-		pointAddress := int(data[offset])
-		fmt.Println(pointAddress)
-
-	// 	num_items = ( tvb_get_guint8(tvb, offset+1) - tvb_get_guint8(tvb, offset) + 1);
-	//   proto_item_set_generated(range_item);
-	//   al_ptaddr = tvb_get_guint8(tvb, offset);
-	//   proto_tree_add_item(range_tree, hf_dnp3_al_range_start8, tvb, offset, 1, ENC_LITTLE_ENDIAN);
-	//   proto_tree_add_item(range_tree, hf_dnp3_al_range_stop8, tvb, offset + 1, 1, ENC_LITTLE_ENDIAN);
-	//   rangebytes = 2;
-
-	case 1:
-	case 2:
-	case 3:
-	case 4:
-	case 5:
-	case 6:
-	case 7:
-	case 8:
-	case 9:
-	case 10:
-	case 11:
-	case 12:
-	case 13:
-	case 14:
-	case 15:
+	d.appObjectBytes = append([]byte(nil), data[objectStart:]...)
 
+	// Objects is left empty (rather than returning an error) when the
+	// object bytes don't parse cleanly, since appObjectBytes above already
+	// preserves them for SerializeTo and callers can tell an empty slice
+	// apart from "no objects present" by checking appObjectBytes.
+	if objects, err := decodeDNP3Objects(d.appObjectBytes); err == nil {
+		d.DNP3ApplicationLayer.Objects = objects
 	}
-	/* Move offset past any range field */
-	offset += rangebytes
-	fmt.Println(offset)
-
-	// RSCArrayFirst := []byte{0, 1, 2, 3, 4, 5}
-
-	// if d.contains(RSCArrayFirst, objectRangeSpecifierCode) {
-
-	// }
-
-	/* Special handling for Octet string objects as the variation is the length of the string */
-	// temp = objTypeField & 0xFF00
-	// if (temp == AL_OBJ_OCT) || (temp == AL_OBJ_OCT_EVT) {
-	// 	al_oct_len = al_obj & 0xFF
-	// 	al_obj = temp
-	// }
-
-	// objectGroup := data[objectStart] & 0x0f
-	// objectGroup := fmt.Sprintf("0x%x%x", data[objectStart], data[objectStart+1])
-
-	// fmt.Println(objectGroup)
-
-	// objectGroup, _ := strconv.Atoi(fmt.Sprintf("%d", data[objectStart]))
-	// objectVariation, _ := strconv.Atoi(fmt.Sprintf("%d", data[objectStart+1]))
-	// fmt.Println(objectGroup)
-	// fmt.Println(objectVariation)
-
-	/* Index Size (3-bits x111xxxx) */
-	// /* When Qualifier Code != 11    */
-	// #define AL_OBJQL_PREFIX_NI     0x00    /* Objects are Packed with no index */
-	// #define AL_OBJQL_PREFIX_1O     0x01    /* Objects are prefixed w/ 1-octet index */
-	// #define AL_OBJQL_PREFIX_2O     0x02    /* Objects are prefixed w/ 2-octet index */
-	// #define AL_OBJQL_PREFIX_4O     0x03    /* Objects are prefixed w/ 4-octet index */
-	// #define AL_OBJQL_PREFIX_1OS    0x04    /* Objects are prefixed w/ 1-octet object size */
-	// #define AL_OBJQL_PREFIX_2OS    0x05    /* Objects are prefixed w/ 2-octet object size */
-	// #define AL_OBJQL_PREFIX_4OS    0x06    /* Objects are prefixed w/ 4-octet object size */
-
-	// /* When Qualifier Code == 11 */
-	// #define AL_OBJQL_IDX11_1OIS    0x01    /* 1 octet identifier size */
-	// #define AL_OBJQL_IDX11_2OIS    0x02    /* 2 octet identifier size */
-	// #define AL_OBJQL_IDX11_4OIS    0x03    /* 4 octet identifier size */
-
-	// /* Qualifier Code (4-bits) */
-	// /* 4-bits ( xxxx1111 ) */
-	// #define AL_OBJQL_RANGE_SSI8    0x00    /* 00 8-bit Start and Stop Indices in Range Field */
-	// #define AL_OBJQL_RANGE_SSI16   0x01    /* 01 16-bit Start and Stop Indices in Range Field */
-	// #define AL_OBJQL_RANGE_SSI32   0x02    /* 02 32-bit Start and Stop Indices in Range Field */
-	// #define AL_OBJQL_RANGE_AA8     0x03    /* 03 8-bit Absolute Address in Range Field */
-	// #define AL_OBJQL_RANGE_AA16    0x04    /* 04 16-bit Absolute Address in Range Field */
-	// #define AL_OBJQL_RANGE_AA32    0x05    /* 05 32-bit Absolute Address in Range Field */
-	// #define AL_OBJQL_RANGE_R0      0x06    /* 06 Length of Range field is 0 (no range field) */
-	// #define AL_OBJQL_RANGE_SF8     0x07    /* 07 8-bit Single Field Quantity */
-	// #define AL_OBJQL_RANGE_SF16    0x08    /* 08 16-bit Single Field Quantity */
-	// #define AL_OBJQL_RANGE_SF32    0x09    /* 09 32-bit Single Field Quantity */
-	//                            /*  0x0A       10 Reserved  */
-	// #define AL_OBJQL_RANGE_FF      0x0B    /* 11 Free-format Qualifier, range field has 1 octet count of objects */
-	//                            /*  0x0C       12 Reserved  */
-	//                            /*  0x0D       13 Reserved  */
-	//                            /*  0x0E       14 Reserved  */
-	//                            /*  0x0F       15 Reserved  */
-
-	/***************************************************************************/
-	/* Application Layer Data Object Qualifier */
-	/***************************************************************************/
-	// /* Bit-Masks */
-	// #define AL_OBJQ_PREFIX         0x70    /* x111xxxx Masks Prefix from Qualifier */
-	// #define AL_OBJQ_RANGE          0x0F    /* xxxx1111 Masks Range from Qualifier */
-
-	// objectQualifier := fmt.Sprintf("0x%d", data[objectStart+2])
-
-	// fmt.Println(objectQualifier)
-
-	// src = []byte{data[objectStart], data[objectStart+1]}
-	// dst = make([]byte, hex.EncodedLen(len(src)))
-	// hex.Encode(dst, src)
-	// prefixCode := fmt.Sprintf("0x%s", dst)
-	// fmt.Println(prefixCode)
+}
 
+// DecodeApplicationPayload re-dissects DNP3ApplicationLayer from fragment, a
+// fully reassembled multi-frame application fragment such as
+// DNP3Reassembler.Accept returns once a fragment's Final segment arrives.
+// Each individual frame's DNP3ApplicationLayer.Objects only ever reflects
+// that frame's own slice of the fragment (see DecodeFromBytes), so a caller
+// pairing DecodeFromBytes with its own DNP3Reassembler should call this once
+// Accept reports a fragment complete to get the real, fully-joined Objects.
+func (d *DNP3) DecodeApplicationPayload(fragment []byte) {
+	d.ApplicationPayload = fragment
+	d.applicationLayer(fragment)
 }
 
 func (d *DNP3) IsDNP3(bytesRead []byte) bool {
@@ -646,26 +830,6 @@ func (d *DNP3) hexConvert(byteArray []byte) string {
 	return "0x" + hex.EncodeToString(byteArray)
 }
 
-func (d *DNP3) isMultiPart(bytesRead []byte) bool {
-	var FirstOfMulti01 byte = 0x40
-	var NotFirstNotLast00 byte = 0x00
-	var FinalFrame10 byte = 0x80
-	var OneFrame11 byte = 0xC0
-
-	TpFinFir := bytesRead[10] & 0xC0
-	switch TpFinFir {
-	case FirstOfMulti01:
-		return false
-	case NotFirstNotLast00:
-		return false
-	case FinalFrame10:
-		return true
-	case OneFrame11:
-		return true
-	}
-	return false
-}
-
 // Contains tells whether a contains x.
 // func (d *DNP3) contains(a []byte, x int) bool {
 // 	for _, n := range a {
@@ -0,0 +1,258 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import "fmt"
+
+//******************************************************************************
+//
+// DNP3 Conversation Tracking
+// ------------------------------------------
+// This file borrows the "flow" abstraction other gopacket layers use to key
+// decoded frames onto a session, and adds a DNP3FlowTracker that follows a
+// master/outstation conversation across both directions: link FCB, transport
+// sequence, outstanding application requests and IIN, so a SCADA
+// monitor/IDS can pair requests with responses and flag anomalies without
+// reimplementing DNP3's sequencing rules.
+//
+//******************************************************************************
+
+// DNP3Flow identifies one direction of a DNP3 conversation by its data-link
+// source and destination addresses.
+type DNP3Flow struct {
+	SourceAddr      int
+	DestinationAddr int
+}
+
+// String returns the flow as "source->destination".
+func (f DNP3Flow) String() string {
+	return fmt.Sprintf("%d->%d", f.SourceAddr, f.DestinationAddr)
+}
+
+// Reverse returns the flow for the opposite direction of the same
+// conversation.
+func (f DNP3Flow) Reverse() DNP3Flow {
+	return DNP3Flow{SourceAddr: f.DestinationAddr, DestinationAddr: f.SourceAddr}
+}
+
+// Flow returns the DNP3Flow for this frame's direction: its data-link
+// source and destination addresses.
+func (d *DNP3) Flow() DNP3Flow {
+	return DNP3Flow{
+		SourceAddr:      d.DNP3DataLinkLayer.Source,
+		DestinationAddr: d.DNP3DataLinkLayer.Destination,
+	}
+}
+
+// Endpoints returns this frame's data-link source and destination
+// addresses.
+func (d *DNP3) Endpoints() (src, dst int) {
+	return d.DNP3DataLinkLayer.Source, d.DNP3DataLinkLayer.Destination
+}
+
+// dnp3SessionKey identifies a master/outstation conversation independent of
+// which direction a given frame travels in, since DNP3FlowTracker needs to
+// match a master's request against the same outstation's response.
+type dnp3SessionKey struct {
+	master, outstation int
+}
+
+// dnp3SessionKeyFor returns the session key for d, using its data-link DIR
+// control bit (IsMaster) to tell which address is the master.
+func dnp3SessionKeyFor(d *DNP3) dnp3SessionKey {
+	link := d.DNP3DataLinkLayer
+	if link.Control.IsMaster == 1 {
+		return dnp3SessionKey{master: link.Source, outstation: link.Destination}
+	}
+	return dnp3SessionKey{master: link.Destination, outstation: link.Source}
+}
+
+// DNP3PendingRequest records a master request's function code and
+// application sequence while DNP3FlowTracker waits for its Confirm or
+// Response.
+type DNP3PendingRequest struct {
+	FunctionCode byte
+	Sequence     int
+}
+
+// DNP3FlowState is the per-session state DNP3FlowTracker accumulates across
+// both directions of one master/outstation conversation.
+type DNP3FlowState struct {
+	// LastFCB is the most recently seen link-layer Frame Count Bit, keyed
+	// by the sending address, so each direction tracks its own bit.
+	LastFCB map[int]int
+	// NextTransportSeq is the transport sequence number expected next from
+	// each sending address.
+	NextTransportSeq map[int]int
+	// Pending holds requests awaiting a Confirm or Response, keyed by
+	// application sequence number.
+	Pending map[int]DNP3PendingRequest
+	// IIN is the most recently latched Internal Indications reported by
+	// the outstation.
+	IIN uint16
+	// UnsolicitedEnabled is set once an "Enable Spontaneous Msg" request
+	// (function code 20) has been observed for this session.
+	UnsolicitedEnabled bool
+}
+
+// DNP3AnomalyKind identifies the condition a DNP3Anomaly reports.
+type DNP3AnomalyKind int
+
+const (
+	// DNP3AnomalySequenceSkip is a transport-layer sequence number that
+	// didn't follow the previous segment from the same sender.
+	DNP3AnomalySequenceSkip DNP3AnomalyKind = iota
+	// DNP3AnomalyUnexpectedUnsolicited is an unsolicited response (function
+	// code 130) seen before the master enabled spontaneous messages.
+	DNP3AnomalyUnexpectedUnsolicited
+	// DNP3AnomalyIINError is an outstation response with an IIN error bit
+	// set (see dnp3IINErrorMask).
+	DNP3AnomalyIINError
+)
+
+// DNP3Anomaly describes a condition DNP3FlowTracker.Track flagged as
+// unexpected for a session.
+type DNP3Anomaly struct {
+	Session DNP3Flow
+	Kind    DNP3AnomalyKind
+	Detail  string
+}
+
+// dnp3IINErrorMask is the set of IIN bits that indicate an outstation-side
+// error rather than routine status; see IINCodes' second-octet entries.
+const dnp3IINErrorMask = 0x0001 | 0x0002 | 0x0004 | 0x0020
+
+// DNP3FlowTracker follows a DNP3 conversation across both directions,
+// maintaining per-session link/transport/application state so a caller can
+// pair requests with responses and catch sequencing or IIN anomalies
+// without re-deriving DNP3's rules from raw frames. It is not safe for
+// concurrent use; callers serializing frames from one capture can share a
+// single tracker.
+type DNP3FlowTracker struct {
+	// OnPair, if non-nil, is called when Track matches a response (function
+	// code 129 or 130) to the outstanding request with the same
+	// application sequence number.
+	OnPair func(session DNP3Flow, request DNP3PendingRequest, response *DNP3)
+	// OnAnomaly, if non-nil, is called whenever Track observes one of the
+	// conditions described by DNP3AnomalyKind.
+	OnAnomaly func(DNP3Anomaly)
+
+	sessions map[dnp3SessionKey]*DNP3FlowState
+}
+
+// NewDNP3FlowTracker creates an empty DNP3FlowTracker.
+func NewDNP3FlowTracker() *DNP3FlowTracker {
+	return &DNP3FlowTracker{sessions: make(map[dnp3SessionKey]*DNP3FlowState)}
+}
+
+// State returns the current DNP3FlowState for d's session, or nil if Track
+// hasn't seen any frame for that session yet.
+func (t *DNP3FlowTracker) State(d *DNP3) *DNP3FlowState {
+	return t.sessions[dnp3SessionKeyFor(d)]
+}
+
+// Track updates the session state for d and invokes OnPair/OnAnomaly as
+// appropriate. Frames should be fed in capture order; out-of-order frames
+// will read as spurious sequence skips.
+func (t *DNP3FlowTracker) Track(d *DNP3) {
+	key := dnp3SessionKeyFor(d)
+	state, ok := t.sessions[key]
+	if !ok {
+		state = &DNP3FlowState{
+			LastFCB:          make(map[int]int),
+			NextTransportSeq: make(map[int]int),
+			Pending:          make(map[int]DNP3PendingRequest),
+		}
+		t.sessions[key] = state
+	}
+
+	link := d.DNP3DataLinkLayer
+	state.LastFCB[link.Source] = link.Control.FCB
+
+	t.trackTransportSequence(key, state, d)
+	t.trackApplication(key, state, d)
+}
+
+// trackTransportSequence flags a gap when a non-First segment doesn't
+// continue the sequence the previous segment from the same sender started,
+// then records the sequence the next segment from that sender should carry.
+func (t *DNP3FlowTracker) trackTransportSequence(key dnp3SessionKey, state *DNP3FlowState, d *DNP3) {
+	src := d.DNP3DataLinkLayer.Source
+	seq := d.DNP3TransportLayer.Sequence
+
+	if expected, seen := state.NextTransportSeq[src]; seen && d.DNP3TransportLayer.First == 0 && seq != expected {
+		t.anomaly(key, DNP3AnomalySequenceSkip,
+			fmt.Sprintf("sender %d: expected transport sequence %d, got %d", src, expected, seq))
+	}
+
+	state.NextTransportSeq[src] = (seq + 1) & 0x3f
+}
+
+// trackApplication latches IIN, pairs responses with their outstanding
+// request, and flags unsolicited responses or IIN error bits. It only looks
+// at DNP3ApplicationLayer on a First transport segment, since that's the
+// only segment DecodeFromBytes parses an application header from;
+// continuation segments leave it zeroed and would otherwise read as spurious
+// Confirms (function code 0).
+func (t *DNP3FlowTracker) trackApplication(key dnp3SessionKey, state *DNP3FlowState, d *DNP3) {
+	if d.DNP3TransportLayer.First == 0 {
+		return
+	}
+
+	app := d.DNP3ApplicationLayer
+	isMaster := d.DNP3DataLinkLayer.Control.IsMaster == 1
+
+	if isMaster {
+		switch app.FunctionCode {
+		case 20: // Enable Spontaneous Msg
+			state.UnsolicitedEnabled = true
+		case 21: // Disable Spontaneous Msg
+			state.UnsolicitedEnabled = false
+		case 0: // Confirm
+			delete(state.Pending, app.Control.Sequence)
+		default:
+			state.Pending[app.Control.Sequence] = DNP3PendingRequest{
+				FunctionCode: app.FunctionCode,
+				Sequence:     app.Control.Sequence,
+			}
+		}
+		return
+	}
+
+	state.IIN = app.IIN
+	if app.IIN&dnp3IINErrorMask != 0 {
+		t.anomaly(key, DNP3AnomalyIINError, fmt.Sprintf("IIN %s", app.IINCode))
+	}
+
+	if app.FunctionCode == 130 && !state.UnsolicitedEnabled { // Unsolicited Response
+		t.anomaly(key, DNP3AnomalyUnexpectedUnsolicited,
+			fmt.Sprintf("outstation %d sent unsolicited response before spontaneous messages were enabled", key.outstation))
+	}
+
+	if app.FunctionCode == 129 || app.FunctionCode == 130 {
+		if req, ok := state.Pending[app.Control.Sequence]; ok {
+			delete(state.Pending, app.Control.Sequence)
+			if t.OnPair != nil {
+				t.OnPair(DNP3Flow{SourceAddr: key.master, DestinationAddr: key.outstation}, req, d)
+			}
+		}
+	}
+}
+
+// anomaly reports a DNP3Anomaly for key's session to OnAnomaly, if set.
+func (t *DNP3FlowTracker) anomaly(key dnp3SessionKey, kind DNP3AnomalyKind, detail string) {
+	if t.OnAnomaly == nil {
+		return
+	}
+	t.OnAnomaly(DNP3Anomaly{
+		Session: DNP3Flow{SourceAddr: key.master, DestinationAddr: key.outstation},
+		Kind:    kind,
+		Detail:  detail,
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import "testing"
+
+// dnp3Frame builds the minimal *DNP3 value DNP3FlowTracker.Track needs: link
+// addresses/IsMaster, a First+Final (single-segment) transport layer, and an
+// application header.
+func dnp3Frame(src, dst int, isMaster bool, transportSeq int, funcCode byte, appSeq int, iin uint16) *DNP3 {
+	d := &DNP3{}
+	d.DNP3DataLinkLayer.Source = src
+	d.DNP3DataLinkLayer.Destination = dst
+	if isMaster {
+		d.DNP3DataLinkLayer.Control.IsMaster = 1
+	}
+	d.DNP3TransportLayer.First = 1
+	d.DNP3TransportLayer.Final = 1
+	d.DNP3TransportLayer.Sequence = transportSeq
+	d.DNP3ApplicationLayer.FunctionCode = funcCode
+	d.DNP3ApplicationLayer.Control.Sequence = appSeq
+	d.DNP3ApplicationLayer.IIN = iin
+	return d
+}
+
+// TestDNP3FlowTrackerPairsRequestAndResponse checks that a master Read
+// request and the outstation's Response with the same application sequence
+// are reported through OnPair.
+func TestDNP3FlowTrackerPairsRequestAndResponse(t *testing.T) {
+	const master, outstation = 1, 4
+
+	var paired struct {
+		session  DNP3Flow
+		request  DNP3PendingRequest
+		response *DNP3
+	}
+	tr := NewDNP3FlowTracker()
+	tr.OnPair = func(session DNP3Flow, request DNP3PendingRequest, response *DNP3) {
+		paired.session, paired.request, paired.response = session, request, response
+	}
+
+	tr.Track(dnp3Frame(master, outstation, true, 0, 1 /* Read */, 3, 0))
+	response := dnp3Frame(outstation, master, false, 0, 129 /* Response */, 3, 0)
+	tr.Track(response)
+
+	if paired.response != response {
+		t.Fatalf("OnPair response = %p, want %p (not called, or called with the wrong frame)", paired.response, response)
+	}
+	if paired.session != (DNP3Flow{SourceAddr: master, DestinationAddr: outstation}) {
+		t.Errorf("OnPair session = %+v, want master->outstation", paired.session)
+	}
+	if paired.request.FunctionCode != 1 || paired.request.Sequence != 3 {
+		t.Errorf("OnPair request = %+v, want {FunctionCode:1 Sequence:3}", paired.request)
+	}
+
+	state := tr.State(dnp3Frame(master, outstation, true, 0, 0, 0, 0))
+	if _, pending := state.Pending[3]; pending {
+		t.Errorf("request sequence 3 still Pending after OnPair fired")
+	}
+}
+
+// TestDNP3FlowTrackerSkipsContinuationSegments checks that a continuation
+// transport segment (First == 0) is never mistaken for a Confirm, even
+// though its DNP3ApplicationLayer is zeroed.
+func TestDNP3FlowTrackerSkipsContinuationSegments(t *testing.T) {
+	const master, outstation = 1, 4
+
+	tr := NewDNP3FlowTracker()
+	tr.Track(dnp3Frame(master, outstation, true, 0, 1 /* Read */, 5, 0))
+
+	// A real continuation segment carries no header, but if trackApplication
+	// didn't skip it, reading its zeroed fields as function code 0 (Confirm)
+	// with this sequence would wrongly clear the still-outstanding request.
+	continuation := dnp3Frame(master, outstation, true, 1, 0, 5, 0)
+	continuation.DNP3TransportLayer.First = 0
+	tr.Track(continuation)
+
+	state := tr.State(dnp3Frame(master, outstation, true, 0, 0, 0, 0))
+	if _, pending := state.Pending[5]; !pending {
+		t.Errorf("request sequence 5 no longer Pending: continuation segment was read as a Confirm")
+	}
+}
+
+// TestDNP3FlowTrackerUnexpectedUnsolicited checks that an unsolicited
+// response before Enable Spontaneous Msg flags an anomaly, and that enabling
+// it first silences that check.
+func TestDNP3FlowTrackerUnexpectedUnsolicited(t *testing.T) {
+	const master, outstation = 1, 4
+
+	var anomalies []DNP3Anomaly
+	tr := NewDNP3FlowTracker()
+	tr.OnAnomaly = func(a DNP3Anomaly) { anomalies = append(anomalies, a) }
+
+	tr.Track(dnp3Frame(outstation, master, false, 0, 130 /* Unsolicited Response */, 0, 0))
+	if len(anomalies) != 1 || anomalies[0].Kind != DNP3AnomalyUnexpectedUnsolicited {
+		t.Fatalf("anomalies = %+v, want one DNP3AnomalyUnexpectedUnsolicited", anomalies)
+	}
+
+	anomalies = nil
+	tr.Track(dnp3Frame(master, outstation, true, 1, 20 /* Enable Spontaneous Msg */, 1, 0))
+	tr.Track(dnp3Frame(outstation, master, false, 1, 130, 0, 0))
+	if len(anomalies) != 0 {
+		t.Errorf("anomalies = %+v, want none once spontaneous messages were enabled", anomalies)
+	}
+}
+
+// TestDNP3FlowTrackerIINError checks that an outstation response with an IIN
+// error bit set flags a DNP3AnomalyIINError.
+func TestDNP3FlowTrackerIINError(t *testing.T) {
+	const master, outstation = 1, 4
+
+	var anomalies []DNP3Anomaly
+	tr := NewDNP3FlowTracker()
+	tr.OnAnomaly = func(a DNP3Anomaly) { anomalies = append(anomalies, a) }
+
+	tr.Track(dnp3Frame(outstation, master, false, 0, 129, 0, 0x0002 /* Requested Objects Unknown */))
+
+	if len(anomalies) != 1 || anomalies[0].Kind != DNP3AnomalyIINError {
+		t.Fatalf("anomalies = %+v, want one DNP3AnomalyIINError", anomalies)
+	}
+}
+
+// TestDNP3FlowTrackerSequenceSkip checks that a non-First transport segment
+// whose sequence doesn't continue the previous one from the same sender
+// flags DNP3AnomalySequenceSkip.
+func TestDNP3FlowTrackerSequenceSkip(t *testing.T) {
+	const master, outstation = 1, 4
+
+	var anomalies []DNP3Anomaly
+	tr := NewDNP3FlowTracker()
+	tr.OnAnomaly = func(a DNP3Anomaly) { anomalies = append(anomalies, a) }
+
+	first := dnp3Frame(outstation, master, false, 0, 1, 0, 0)
+	first.DNP3TransportLayer.Final = 0
+	tr.Track(first)
+
+	gapped := dnp3Frame(outstation, master, false, 2, 0, 0, 0)
+	gapped.DNP3TransportLayer.First = 0
+	tr.Track(gapped)
+
+	if len(anomalies) != 1 || anomalies[0].Kind != DNP3AnomalySequenceSkip {
+		t.Fatalf("anomalies = %+v, want one DNP3AnomalySequenceSkip", anomalies)
+	}
+}
@@ -0,0 +1,676 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+//******************************************************************************
+//
+// DNP3 Application-Layer Object Dissection
+// ------------------------------------------
+// This file turns the application-layer object bytes that follow the
+// application header into a slice of DNP3AppObject, decoding the common
+// object groups into typed values.
+//
+//******************************************************************************
+
+// DNP3Flags is the point-quality flags byte shared by most DNP3 static and
+// event object variations.
+type DNP3Flags struct {
+	Online        bool
+	Restart       bool
+	CommLost      bool
+	RemoteForced  bool
+	LocalForced   bool
+	ChatterFilter bool
+	// State is the point's own value bit (e.g. ON/OFF) for binary types,
+	// or reserved for analog types.
+	State    bool
+	Reserved bool
+}
+
+func decodeDNP3Flags(b byte) DNP3Flags {
+	return DNP3Flags{
+		Online:        b&0x01 != 0,
+		Restart:       b&0x02 != 0,
+		CommLost:      b&0x04 != 0,
+		RemoteForced:  b&0x08 != 0,
+		LocalForced:   b&0x10 != 0,
+		ChatterFilter: b&0x20 != 0,
+		State:         b&0x40 != 0,
+		Reserved:      b&0x80 != 0,
+	}
+}
+
+// DNP3Time is a 48-bit DNP3 timestamp: milliseconds since the Unix epoch.
+type DNP3Time struct {
+	Milliseconds uint64
+}
+
+func decodeDNP3Time(b []byte) DNP3Time {
+	var ms uint64
+	for i := 5; i >= 0; i-- {
+		ms = ms<<8 | uint64(b[i])
+	}
+	return DNP3Time{Milliseconds: ms}
+}
+
+// DNP3BinaryInput is a decoded g1 (Binary Input) point.
+type DNP3BinaryInput struct {
+	Value bool
+	// Flags is nil for the packed-bit variation (g1v1), which carries no
+	// quality flags.
+	Flags *DNP3Flags
+}
+
+// DNP3BinaryInputEvent is a decoded g2 (Binary Input Event) point.
+type DNP3BinaryInputEvent struct {
+	Value bool
+	Flags DNP3Flags
+	Time  *DNP3Time
+}
+
+// DNP3BinaryOutputStatus is a decoded g10v2 (Binary Output Status) point.
+type DNP3BinaryOutputStatus struct {
+	Value bool
+	Flags DNP3Flags
+}
+
+// DNP3CROB is a decoded g12v1 (Control Relay Output Block) point.
+type DNP3CROB struct {
+	ControlCode byte
+	Count       byte
+	OnTimeMs    uint32
+	OffTimeMs   uint32
+	Status      byte
+}
+
+// DNP3Counter is a decoded g20/g21 (Counter/Frozen Counter) point, or a
+// g22/g23 (Counter/Frozen Counter Event) point if Time is set.
+type DNP3Counter struct {
+	Value uint32
+	Flags DNP3Flags
+	Time  *DNP3Time
+}
+
+// DNP3AnalogInput is a decoded g30 (Analog Input) point, or a g32 (Analog
+// Input Event) point if Time is set. Value is normalized to a float64
+// regardless of the variation's wire width, and Flags is the zero value
+// for the flag-less g30v3/v4 variations.
+type DNP3AnalogInput struct {
+	Value float64
+	Flags DNP3Flags
+	Time  *DNP3Time
+}
+
+// DNP3AnalogOutputStatus is a decoded g40 (Analog Output Status) point.
+type DNP3AnalogOutputStatus struct {
+	Value float64
+	Flags DNP3Flags
+}
+
+// DNP3AnalogOutputCommand is a decoded g41 (Analog Output Block) point.
+type DNP3AnalogOutputCommand struct {
+	Value  float64
+	Status byte
+}
+
+// DNP3TimeAndDate is a decoded g50/g51 (Time and Date) point.
+type DNP3TimeAndDate struct {
+	Time DNP3Time
+}
+
+// DNP3TimeDelay is a decoded g52 (Time Delay) point, in milliseconds.
+type DNP3TimeDelay struct {
+	DelayMs uint16
+}
+
+// DNP3OctetString is a decoded g110/g111 (Octet String) point; its length
+// is carried by the object's variation rather than being fixed per group.
+type DNP3OctetString struct {
+	Data []byte
+}
+
+// decodeDNP3Objects parses the application-layer object bytes that follow
+// the application header into a slice of DNP3AppObject. A misparsed object
+// header or range field aborts the whole slice, since it desyncs every
+// object after it; the caller falls back to treating the bytes as opaque.
+func decodeDNP3Objects(data []byte) ([]DNP3AppObject, error) {
+	var objects []DNP3AppObject
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("dnp3: %d trailing byte(s) too short for an object header", len(data))
+		}
+
+		group := int(data[0])
+		variation := int(data[1])
+		qualifier := data[2]
+		prefixCode := (qualifier & 0x70) >> 4
+		rangeCode := qualifier & 0x0f
+
+		count, startIndex, rangeLen, err := decodeDNP3Range(rangeCode, data[3:])
+		if err != nil {
+			return nil, fmt.Errorf("dnp3: group %d variation %d: %v", group, variation, err)
+		}
+
+		itemsStart := 3 + rangeLen
+		obj := DNP3AppObject{
+			Group:     group,
+			Variation: variation,
+			Qualifier: int(qualifier),
+			DataType:  int(rangeCode),
+			Length:    count,
+		}
+		if rangeCode <= 5 {
+			obj.RangeStart = startIndex
+			obj.RangeStop = startIndex + count - 1
+		}
+
+		consumed, err := decodeDNP3Items(&obj, prefixCode, startIndex, count, data[itemsStart:])
+		if err != nil {
+			return nil, fmt.Errorf("dnp3: group %d variation %d: %v", group, variation, err)
+		}
+
+		blockLen := itemsStart + consumed
+		obj.RawData = append([]byte(nil), data[:blockLen]...)
+		objects = append(objects, obj)
+		data = data[blockLen:]
+	}
+
+	return objects, nil
+}
+
+// decodeDNP3Range decodes an object qualifier's range field (the bytes
+// following group/variation/qualifier) for the given range specifier code
+// (RSC), returning the number of items it describes, the starting point
+// index for the start/stop and absolute-address codes, and how many bytes
+// of field were consumed.
+func decodeDNP3Range(rangeCode byte, field []byte) (count, start, consumed int, err error) {
+	need := func(n int) error {
+		if len(field) < n {
+			return fmt.Errorf("range field too short for RSC %d", rangeCode)
+		}
+		return nil
+	}
+
+	switch rangeCode {
+	case 0: // 8-bit start/stop indices
+		if err = need(2); err != nil {
+			return
+		}
+		start, count, consumed = int(field[0]), int(field[1])-int(field[0])+1, 2
+	case 1: // 16-bit start/stop indices
+		if err = need(4); err != nil {
+			return
+		}
+		start = int(binary.LittleEndian.Uint16(field[0:2]))
+		count = int(binary.LittleEndian.Uint16(field[2:4])) - start + 1
+		consumed = 4
+	case 2: // 32-bit start/stop indices
+		if err = need(8); err != nil {
+			return
+		}
+		start = int(binary.LittleEndian.Uint32(field[0:4]))
+		count = int(binary.LittleEndian.Uint32(field[4:8])) - start + 1
+		consumed = 8
+	case 3: // 8-bit absolute address
+		if err = need(1); err != nil {
+			return
+		}
+		start, count, consumed = int(field[0]), 1, 1
+	case 4: // 16-bit absolute address
+		if err = need(2); err != nil {
+			return
+		}
+		start, count, consumed = int(binary.LittleEndian.Uint16(field[0:2])), 1, 2
+	case 5: // 32-bit absolute address
+		if err = need(4); err != nil {
+			return
+		}
+		start, count, consumed = int(binary.LittleEndian.Uint32(field[0:4])), 1, 4
+	case 6: // no range field
+		count, consumed = 0, 0
+	case 7: // 8-bit count of objects
+		if err = need(1); err != nil {
+			return
+		}
+		count, consumed = int(field[0]), 1
+	case 8: // 16-bit count of objects
+		if err = need(2); err != nil {
+			return
+		}
+		count, consumed = int(binary.LittleEndian.Uint16(field[0:2])), 2
+	case 9: // 32-bit count of objects
+		if err = need(4); err != nil {
+			return
+		}
+		count, consumed = int(binary.LittleEndian.Uint32(field[0:4])), 4
+	case 11: // free-format qualifier, 1-octet count of objects
+		if err = need(1); err != nil {
+			return
+		}
+		count, consumed = int(field[0]), 1
+	default:
+		err = fmt.Errorf("unsupported range specifier code %d", rangeCode)
+	}
+	return
+}
+
+// decodeDNP3Items decodes count items following an object's range field,
+// appending them to obj.Items, and returns how many bytes they consumed.
+// prefixCode selects the per-item index/size prefix width (ObjPrefixCodes);
+// startIndex/count come from decodeDNP3Range.
+func decodeDNP3Items(obj *DNP3AppObject, prefixCode byte, startIndex, count int, data []byte) (int, error) {
+	if count == 0 {
+		return 0, nil
+	}
+
+	// g1v1 binary inputs are packed one bit per point rather than one
+	// byte (or prefixed item) per point.
+	if obj.Group == 1 && obj.Variation == 1 {
+		return decodeDNP3PackedBinaryInputs(obj, startIndex, count, data)
+	}
+
+	prefixWidth, sizeIsPrefixed, err := dnp3PrefixWidth(prefixCode)
+	if err != nil {
+		return 0, err
+	}
+
+	fixedLen, hasFixedLen := dnp3FixedItemLength(obj.Group, obj.Variation)
+
+	offset := 0
+	for i := 0; i < count; i++ {
+		if len(data)-offset < prefixWidth {
+			return 0, fmt.Errorf("truncated item prefix")
+		}
+
+		index := startIndex + i
+		prefixVal := 0
+		if prefixWidth > 0 {
+			prefixVal = dnp3ReadUint(data[offset : offset+prefixWidth])
+			if prefixCode >= 1 && prefixCode <= 3 {
+				index = prefixVal
+			}
+		}
+		offset += prefixWidth
+
+		itemLen := fixedLen
+		switch {
+		case sizeIsPrefixed:
+			itemLen = prefixVal
+		case obj.Group == 110 || obj.Group == 111:
+			// Octet strings encode their length in the variation itself.
+			itemLen = obj.Variation
+		case !hasFixedLen:
+			return 0, fmt.Errorf("no known item length for group %d variation %d", obj.Group, obj.Variation)
+		}
+
+		if len(data)-offset < itemLen {
+			return 0, fmt.Errorf("truncated item data")
+		}
+
+		raw := data[offset : offset+itemLen]
+		obj.Items = append(obj.Items, DNP3ObjectItem{
+			Index: index,
+			Value: decodeDNP3ItemValue(obj.Group, obj.Variation, raw),
+			Raw:   append([]byte(nil), raw...),
+		})
+		offset += itemLen
+	}
+
+	return offset, nil
+}
+
+// decodeDNP3PackedBinaryInputs decodes g1v1: count 1-bit values packed LSB
+// first into ceil(count/8) bytes.
+func decodeDNP3PackedBinaryInputs(obj *DNP3AppObject, startIndex, count int, data []byte) (int, error) {
+	nBytes := (count + 7) / 8
+	if len(data) < nBytes {
+		return 0, fmt.Errorf("truncated packed binary input data")
+	}
+	for i := 0; i < count; i++ {
+		byteIdx, bitIdx := i/8, uint(i%8)
+		value := data[byteIdx]>>bitIdx&0x01 != 0
+		obj.Items = append(obj.Items, DNP3ObjectItem{
+			Index: startIndex + i,
+			Value: DNP3BinaryInput{Value: value},
+			Raw:   data[byteIdx : byteIdx+1],
+		})
+	}
+	return nBytes, nil
+}
+
+// dnp3PrefixWidth returns the per-item prefix width in bytes for an object
+// prefix code (ObjPrefixCodes), and whether that prefix carries an object
+// size (true) rather than an index (false).
+func dnp3PrefixWidth(prefixCode byte) (width int, sizeIsPrefixed bool, err error) {
+	switch prefixCode {
+	case 0:
+		return 0, false, nil
+	case 1:
+		return 1, false, nil
+	case 2:
+		return 2, false, nil
+	case 3:
+		return 4, false, nil
+	case 4:
+		return 1, true, nil
+	case 5:
+		return 2, true, nil
+	case 6:
+		return 4, true, nil
+	default:
+		return 0, false, fmt.Errorf("unsupported object prefix code %d", prefixCode)
+	}
+}
+
+// dnp3ReadUint reads a little-endian unsigned integer of 1, 2 or 4 bytes.
+func dnp3ReadUint(b []byte) int {
+	switch len(b) {
+	case 1:
+		return int(b[0])
+	case 2:
+		return int(binary.LittleEndian.Uint16(b))
+	case 4:
+		return int(binary.LittleEndian.Uint32(b))
+	default:
+		return 0
+	}
+}
+
+// dnp3AnalogLayout describes how one g30/g32/g40/g41 variation lays out its
+// analog value: whether it's preceded by a flags byte, its width and
+// numeric kind, and whether it's followed by a 6-byte time (g32 only).
+type dnp3AnalogLayout struct {
+	hasFlags bool
+	isFloat  bool
+	width    int // value width in bytes: 2, 4 or 8
+	hasTime  bool
+}
+
+// dnp3AnalogLayoutFor returns the wire layout for a g30/g32/g40/g41
+// variation, and whether that variation is recognized at all.
+func dnp3AnalogLayoutFor(group, variation int) (dnp3AnalogLayout, bool) {
+	switch group {
+	case 30: // Analog Input
+		switch variation {
+		case 1:
+			return dnp3AnalogLayout{hasFlags: true, width: 4}, true
+		case 2:
+			return dnp3AnalogLayout{hasFlags: true, width: 2}, true
+		case 3:
+			return dnp3AnalogLayout{width: 4}, true
+		case 4:
+			return dnp3AnalogLayout{width: 2}, true
+		case 5:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 4}, true
+		case 6:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 8}, true
+		}
+	case 32: // Analog Input Event
+		switch variation {
+		case 1:
+			return dnp3AnalogLayout{hasFlags: true, width: 4}, true
+		case 2:
+			return dnp3AnalogLayout{hasFlags: true, width: 2}, true
+		case 3:
+			return dnp3AnalogLayout{hasFlags: true, width: 4, hasTime: true}, true
+		case 4:
+			return dnp3AnalogLayout{hasFlags: true, width: 2, hasTime: true}, true
+		case 5:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 4}, true
+		case 6:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 8}, true
+		case 7:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 4, hasTime: true}, true
+		case 8:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 8, hasTime: true}, true
+		}
+	case 40: // Analog Output Status
+		switch variation {
+		case 1:
+			return dnp3AnalogLayout{hasFlags: true, width: 4}, true
+		case 2:
+			return dnp3AnalogLayout{hasFlags: true, width: 2}, true
+		case 3:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 4}, true
+		case 4:
+			return dnp3AnalogLayout{hasFlags: true, isFloat: true, width: 8}, true
+		}
+	case 41: // Analog Output Block (command); the trailing status byte is
+		// handled by the caller, not by this value layout.
+		switch variation {
+		case 1:
+			return dnp3AnalogLayout{width: 4}, true
+		case 2:
+			return dnp3AnalogLayout{width: 2}, true
+		case 3:
+			return dnp3AnalogLayout{isFloat: true, width: 4}, true
+		case 4:
+			return dnp3AnalogLayout{isFloat: true, width: 8}, true
+		}
+	}
+	return dnp3AnalogLayout{}, false
+}
+
+// byteLen is the on-wire length of the flags/value/time portion this
+// layout describes.
+func (l dnp3AnalogLayout) byteLen() int {
+	n := l.width
+	if l.hasFlags {
+		n++
+	}
+	if l.hasTime {
+		n += 6
+	}
+	return n
+}
+
+// dnp3FixedItemLength returns the on-wire byte length of a single point for
+// (group, variation) pairs whose size doesn't come from a size prefix or
+// (for octet strings) the variation field itself.
+func dnp3FixedItemLength(group, variation int) (int, bool) {
+	switch group {
+	case 1: // Binary Input
+		if variation == 2 {
+			return 1, true // flags
+		}
+	case 2: // Binary Input Event
+		switch variation {
+		case 1:
+			return 1, true // flags
+		case 2:
+			return 1 + 6, true // flags + absolute time
+		case 3:
+			return 1 + 2, true // flags + relative time
+		}
+	case 10: // Binary Output Status
+		if variation == 2 {
+			return 1, true // flags
+		}
+	case 12: // Control Block
+		if variation == 1 {
+			return 11, true // CROB: code+count+on+off+status
+		}
+	case 20, 21: // Counter, Frozen Counter
+		switch variation {
+		case 1:
+			return 1 + 4, true // flags + 32-bit count
+		case 2:
+			return 1 + 2, true // flags + 16-bit count
+		}
+	case 22, 23: // Counter Event, Frozen Counter Event
+		switch variation {
+		case 1:
+			return 1 + 4, true // flags + 32-bit count, no time
+		case 2:
+			return 1 + 2, true // flags + 16-bit count, no time
+		case 5:
+			return 1 + 4 + 6, true // flags + 32-bit count + time
+		case 6:
+			return 1 + 2 + 6, true // flags + 16-bit count + time
+		}
+	case 30, 32, 40: // Analog Input(Event), Analog Output Status
+		if layout, ok := dnp3AnalogLayoutFor(group, variation); ok {
+			return layout.byteLen(), true
+		}
+	case 41: // Analog Output Block: value layout plus a status byte
+		if layout, ok := dnp3AnalogLayoutFor(group, variation); ok {
+			return layout.byteLen() + 1, true
+		}
+	case 50, 51: // Time and Date, Time and Date CTO
+		if variation == 1 || variation == 2 {
+			return 6, true // absolute time
+		}
+	case 52: // Time Delay
+		if variation == 1 || variation == 2 {
+			return 2, true // coarse/fine delay, ms
+		}
+	}
+	return 0, false
+}
+
+// decodeDNP3ItemValue decodes raw item bytes into a typed value for the
+// groups/variations dnp3FixedItemLength and decodeDNP3PackedBinaryInputs
+// know the layout of, and for g110/g111 octet strings, returning nil for
+// anything else so its Raw bytes are preserved without loss.
+func decodeDNP3ItemValue(group, variation int, raw []byte) interface{} {
+	switch group {
+	case 1: // Binary Input (v1 is handled separately, as it's packed)
+		if variation == 2 && len(raw) == 1 {
+			flags := decodeDNP3Flags(raw[0])
+			return DNP3BinaryInput{Value: flags.State, Flags: &flags}
+		}
+	case 2: // Binary Input Event
+		if len(raw) < 1 {
+			return nil
+		}
+		flags := decodeDNP3Flags(raw[0])
+		ev := DNP3BinaryInputEvent{Value: flags.State, Flags: flags}
+		if variation == 2 && len(raw) == 7 {
+			t := decodeDNP3Time(raw[1:7])
+			ev.Time = &t
+		}
+		return ev
+	case 10: // Binary Output Status
+		if variation == 2 && len(raw) == 1 {
+			flags := decodeDNP3Flags(raw[0])
+			return DNP3BinaryOutputStatus{Value: flags.State, Flags: flags}
+		}
+	case 12: // CROB
+		if variation == 1 && len(raw) == 11 {
+			return DNP3CROB{
+				ControlCode: raw[0],
+				Count:       raw[1],
+				OnTimeMs:    binary.LittleEndian.Uint32(raw[2:6]),
+				OffTimeMs:   binary.LittleEndian.Uint32(raw[6:10]),
+				Status:      raw[10],
+			}
+		}
+	case 20, 21, 22, 23: // Counter, Frozen Counter, and their events
+		if len(raw) < 1 {
+			return nil
+		}
+		return decodeDNP3Counter(raw)
+	case 30, 32: // Analog Input, Analog Input Event
+		if layout, ok := dnp3AnalogLayoutFor(group, variation); ok && len(raw) >= layout.byteLen() {
+			return decodeDNP3AnalogInput(layout, raw)
+		}
+	case 40: // Analog Output Status
+		if layout, ok := dnp3AnalogLayoutFor(group, variation); ok && layout.hasFlags && len(raw) >= layout.byteLen() {
+			flags := decodeDNP3Flags(raw[0])
+			return DNP3AnalogOutputStatus{Value: decodeDNP3AnalogValue(layout, raw[1:]), Flags: flags}
+		}
+	case 41: // Analog Output Block
+		if layout, ok := dnp3AnalogLayoutFor(group, variation); ok && len(raw) >= layout.byteLen()+1 {
+			n := len(raw) - 1
+			return DNP3AnalogOutputCommand{Value: decodeDNP3AnalogValue(layout, raw[:n]), Status: raw[n]}
+		}
+	case 50, 51: // Time and Date, Time and Date CTO
+		if len(raw) == 6 {
+			return DNP3TimeAndDate{Time: decodeDNP3Time(raw)}
+		}
+	case 52: // Time Delay
+		if len(raw) == 2 {
+			return DNP3TimeDelay{DelayMs: binary.LittleEndian.Uint16(raw)}
+		}
+	case 110, 111: // Octet String, Octet String Event
+		return DNP3OctetString{Data: append([]byte(nil), raw...)}
+	}
+	return nil
+}
+
+// decodeDNP3Counter decodes a g20/g21/g22/g23 point: a flags byte, a
+// 16- or 32-bit count, and (for the longer event variations) a trailing
+// 6-byte time.
+func decodeDNP3Counter(raw []byte) DNP3Counter {
+	flags := decodeDNP3Flags(raw[0])
+	body := raw[1:]
+
+	var t *DNP3Time
+	if len(body) == 8 || len(body) == 10 {
+		n := len(body) - 6
+		timeVal := decodeDNP3Time(body[n:])
+		t = &timeVal
+		body = body[:n]
+	}
+
+	var value uint32
+	switch len(body) {
+	case 2:
+		value = uint32(binary.LittleEndian.Uint16(body))
+	case 4:
+		value = binary.LittleEndian.Uint32(body)
+	}
+
+	return DNP3Counter{Value: value, Flags: flags, Time: t}
+}
+
+// decodeDNP3AnalogInput decodes a g30/g32 point per its layout: an optional
+// flags byte, the analog value, and an optional trailing time.
+func decodeDNP3AnalogInput(layout dnp3AnalogLayout, raw []byte) DNP3AnalogInput {
+	var flags DNP3Flags
+	body := raw
+	if layout.hasFlags {
+		flags = decodeDNP3Flags(raw[0])
+		body = raw[1:]
+	}
+
+	var t *DNP3Time
+	if layout.hasTime {
+		n := len(body) - 6
+		timeVal := decodeDNP3Time(body[n:])
+		t = &timeVal
+		body = body[:n]
+	}
+
+	return DNP3AnalogInput{Value: decodeDNP3AnalogValue(layout, body), Flags: flags, Time: t}
+}
+
+// decodeDNP3AnalogValue decodes an analog value's body (its flags and any
+// trailing time already stripped) according to its layout's width and kind.
+func decodeDNP3AnalogValue(layout dnp3AnalogLayout, body []byte) float64 {
+	switch {
+	case layout.width == 2 && len(body) == 2:
+		return float64(int16(binary.LittleEndian.Uint16(body)))
+	case layout.width == 4 && layout.isFloat && len(body) == 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(body)))
+	case layout.width == 4 && len(body) == 4:
+		return float64(int32(binary.LittleEndian.Uint32(body)))
+	case layout.width == 8 && len(body) == 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(body))
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestDecodeDNP3Objects covers the object shapes a Read request, a Response
+// and an Unsolicited Response typically carry, plus the malformed
+// size-prefixed object (reported against decodeDNP3ItemValue) that used to
+// panic instead of decoding to a nil value.
+func TestDecodeDNP3Objects(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []DNP3AppObject
+		wantErr bool
+	}{
+		{
+			// Read request: "give me all Binary Inputs" (g1v0, RSC 6: no
+			// range field, no items).
+			name: "read request, group 1 variation 0, no range",
+			data: []byte{1, 0, 0x06},
+			want: []DNP3AppObject{{
+				Group: 1, Variation: 0, Qualifier: 0x06, DataType: 6,
+				RawData: []byte{1, 0, 0x06},
+			}},
+		},
+		{
+			// Response: two Binary Input Events (g2v1), unprefixed, RSC 7
+			// (8-bit count of objects).
+			name: "response, group 2 variation 1 events, count-based",
+			data: []byte{2, 1, 0x07, 2, 0x41, 0x01},
+			want: []DNP3AppObject{{
+				Group: 2, Variation: 1, Qualifier: 0x07, DataType: 7, Length: 2,
+				Items: []DNP3ObjectItem{
+					{Index: 0, Value: DNP3BinaryInputEvent{Value: true, Flags: decodeDNP3Flags(0x41)}, Raw: []byte{0x41}},
+					{Index: 1, Value: DNP3BinaryInputEvent{Value: false, Flags: decodeDNP3Flags(0x01)}, Raw: []byte{0x01}},
+				},
+				RawData: []byte{2, 1, 0x07, 2, 0x41, 0x01},
+			}},
+		},
+		{
+			// Unsolicited Response: two static Binary Inputs (g1v2),
+			// unprefixed, RSC 0 (8-bit start/stop indices 0-1).
+			name: "unsolicited response, group 1 variation 2, start/stop range",
+			data: []byte{1, 2, 0x00, 0, 1, 0x41, 0x01},
+			want: []DNP3AppObject{{
+				Group: 1, Variation: 2, Qualifier: 0x00, DataType: 0,
+				RangeStart: 0, RangeStop: 1, Length: 2,
+				Items: []DNP3ObjectItem{
+					{Index: 0, Value: DNP3BinaryInput{Value: true, Flags: flagsPtr(0x41)}, Raw: []byte{0x41}},
+					{Index: 1, Value: DNP3BinaryInput{Value: false, Flags: flagsPtr(0x01)}, Raw: []byte{0x01}},
+				},
+				RawData: []byte{1, 2, 0x00, 0, 1, 0x41, 0x01},
+			}},
+		},
+		{
+			// Malformed: a g20v1 Counter with a 1-octet object-size prefix
+			// (OPC 4) whose on-wire size is 0. decodeDNP3ItemValue must not
+			// panic indexing an empty raw slice; the item decodes to a nil
+			// Value with empty Raw instead.
+			name: "malformed size-prefixed counter with zero size",
+			data: []byte{20, 1, 0x47, 1, 0},
+			want: []DNP3AppObject{{
+				Group: 20, Variation: 1, Qualifier: 0x47, DataType: 7, Length: 1,
+				Items:   []DNP3ObjectItem{{Index: 0, Value: nil, Raw: nil}},
+				RawData: []byte{20, 1, 0x47, 1, 0},
+			}},
+		},
+		{
+			name:    "truncated object header",
+			data:    []byte{1, 2},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeDNP3Objects(tc.data)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("decodeDNP3Objects() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("decodeDNP3Objects() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// flagsPtr is a test helper building the *DNP3Flags decodeDNP3ItemValue
+// returns for g1v2 points.
+func flagsPtr(b byte) *DNP3Flags {
+	f := decodeDNP3Flags(b)
+	return &f
+}
@@ -0,0 +1,119 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package layers
+
+import "sync"
+
+//******************************************************************************
+//
+// DNP3 Transport-Layer Reassembly
+// ------------------------------------------
+// A DNP3 application fragment (up to ~2KB) can be split across many
+// 250-byte link frames by the transport layer. This file joins those
+// frames' transport segments back into one application fragment, in the
+// spirit of tcpassembly's stream reassembly but scoped to a single DNP3
+// transport sequence instead of a full TCP stream.
+//
+//******************************************************************************
+
+// dnp3ReassemblyKey scopes in-progress reassembly to one direction of a
+// DNP3 link-layer conversation: each direction carries its own independent
+// transport sequence, so source and destination together identify a
+// reassembly stream.
+type dnp3ReassemblyKey struct {
+	src, dst int
+}
+
+// dnp3PartialFragment is the in-progress state for one reassembly stream.
+type dnp3PartialFragment struct {
+	data    []byte
+	nextSeq int
+}
+
+// DNP3Reassembler joins the transport-layer segments of a multi-frame DNP3
+// application fragment back into the single contiguous byte stream
+// DecodeApplicationPayload expects, keyed per (source, destination)
+// direction. It is safe for concurrent use by multiple goroutines, but
+// every direction's address pair is assumed to belong to one DNP3 network:
+// decodeDNP3 does not construct or share one by default, so callers
+// decoding unrelated captures that happen to reuse the same addresses
+// should each construct their own DNP3Reassembler via NewDNP3Reassembler,
+// the way dnp3assembly.Factory does per TCP stream.
+//
+// Feed it one decoded frame at a time via Accept. A sequence gap discards
+// whatever was buffered for that direction, along with the gapped segment,
+// and reassembly resumes only at the next First segment. A First segment
+// arriving while a stream is already in progress discards what was
+// buffered and restarts reassembly from the new segment.
+type DNP3Reassembler struct {
+	// OnFragment, if non-nil, is called with the source, destination and
+	// fully reassembled application-layer bytes whenever Accept completes
+	// a fragment.
+	OnFragment func(src, dst int, fragment []byte)
+
+	mu      sync.Mutex
+	partial map[dnp3ReassemblyKey]*dnp3PartialFragment
+}
+
+// NewDNP3Reassembler creates an empty DNP3Reassembler.
+func NewDNP3Reassembler() *DNP3Reassembler {
+	return &DNP3Reassembler{partial: make(map[dnp3ReassemblyKey]*dnp3PartialFragment)}
+}
+
+// Accept feeds one decoded frame's transport segment (d.DNP3TransportLayer
+// and d.restOfData) into the reassembler. It returns the fully reassembled
+// application fragment and true once a properly-sequenced run of segments
+// ends with Final set; otherwise it returns nil, false, either because the
+// fragment is still incomplete or because it was discarded due to a
+// sequence gap or duplicate First segment.
+func (r *DNP3Reassembler) Accept(d *DNP3) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := d.DNP3TransportLayer
+	key := dnp3ReassemblyKey{src: d.DNP3DataLinkLayer.Source, dst: d.DNP3DataLinkLayer.Destination}
+
+	if t.First == 1 {
+		// A new First segment always (re)starts the stream, discarding
+		// any previous partial fragment for this direction.
+		r.partial[key] = &dnp3PartialFragment{
+			data:    append([]byte(nil), d.restOfData...),
+			nextSeq: (t.Sequence + 1) & 0x3f,
+		}
+		return r.complete(key, t.Final == 1)
+	}
+
+	frag, ok := r.partial[key]
+	if !ok || t.Sequence != frag.nextSeq {
+		// No First segment seen yet, or a sequence gap: drop any partial
+		// state and wait for the next First segment.
+		delete(r.partial, key)
+		return nil, false
+	}
+
+	frag.data = append(frag.data, d.restOfData...)
+	frag.nextSeq = (frag.nextSeq + 1) & 0x3f
+
+	return r.complete(key, t.Final == 1)
+}
+
+// complete delivers and clears the partial fragment for key when final is
+// true, otherwise it leaves the fragment buffered for more segments.
+func (r *DNP3Reassembler) complete(key dnp3ReassemblyKey, final bool) ([]byte, bool) {
+	if !final {
+		return nil, false
+	}
+
+	fragment := r.partial[key].data
+	delete(r.partial, key)
+	if r.OnFragment != nil {
+		r.OnFragment(key.src, key.dst, fragment)
+	}
+	return fragment, true
+}
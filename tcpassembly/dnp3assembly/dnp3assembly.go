@@ -0,0 +1,123 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+// Package dnp3assembly provides a tcpassembly.StreamFactory that frames DNP3
+// link PDUs out of a reassembled TCP byte stream and feeds them into a
+// layers.DNP3Reassembler, so a long-running capture of a DNP3 TCP session
+// yields complete application fragments instead of raw stream bytes.
+package dnp3assembly
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// dnp3BlockSize mirrors the unexported block size DNP3's data-link layer
+// CRCs over (see layers.DNP3.verifyAndStripCRCs): every 16 octets of user
+// data on the wire carries its own trailing 2-octet CRC, which the
+// link-layer length byte does not count.
+const dnp3BlockSize = 16
+
+// Factory builds a Stream per TCP direction tcpassembly asks it to
+// reassemble. Streams report completed application fragments through
+// OnFragment; nothing correlates the two directions of a conversation
+// beyond what each Stream's DNP3Reassembler already keys by source and
+// destination address.
+type Factory struct {
+	// OnFragment is called with the source, destination and fully
+	// reassembled application fragment whenever a Stream completes one.
+	OnFragment func(src, dst int, fragment []byte)
+}
+
+// NewFactory creates a Factory that reports completed fragments to
+// onFragment.
+func NewFactory(onFragment func(src, dst int, fragment []byte)) *Factory {
+	return &Factory{OnFragment: onFragment}
+}
+
+// New implements tcpassembly.StreamFactory.
+func (f *Factory) New(net, transport gopacket.Flow) tcpassembly.Stream {
+	return &Stream{
+		reassembler: layers.NewDNP3Reassembler(),
+		onFragment:  f.OnFragment,
+	}
+}
+
+// Stream scans one direction of a reassembled TCP stream for DNP3 link PDUs
+// and feeds each one into a DNP3Reassembler.
+type Stream struct {
+	buf         []byte
+	reassembler *layers.DNP3Reassembler
+	onFragment  func(src, dst int, fragment []byte)
+}
+
+// Reassembled implements tcpassembly.Stream.
+func (s *Stream) Reassembled(reassembled []tcpassembly.Reassembly) {
+	for _, r := range reassembled {
+		s.buf = append(s.buf, r.Bytes...)
+	}
+	s.consume()
+}
+
+// ReassemblyComplete implements tcpassembly.Stream.
+func (s *Stream) ReassemblyComplete() {}
+
+// consume decodes and accepts every complete link PDU currently buffered,
+// discarding each frame's bytes from s.buf as it goes.
+func (s *Stream) consume() {
+	for {
+		frame, ok := s.nextFrame()
+		if !ok {
+			return
+		}
+
+		d := &layers.DNP3{LenientCRC: true}
+		if err := d.DecodeFromBytes(frame, gopacket.NilDecodeFeedback); err != nil {
+			continue
+		}
+
+		if fragment, ok := s.reassembler.Accept(d); ok && s.onFragment != nil {
+			s.onFragment(d.DNP3DataLinkLayer.Source, d.DNP3DataLinkLayer.Destination, fragment)
+		}
+	}
+}
+
+// nextFrame looks for the 0x0564 start delimiter in s.buf, then uses the
+// length byte that follows it (and the CRC layout every 16-octet user-data
+// block carries) to find where that link PDU ends. It returns false until
+// s.buf holds a full frame, and resyncs a byte at a time past anything that
+// doesn't look like a delimiter.
+func (s *Stream) nextFrame() ([]byte, bool) {
+	for len(s.buf) >= layers.MIN_HEADER_LENGTH {
+		if binary.BigEndian.Uint16(s.buf[0:2]) != layers.START_FIELD {
+			s.buf = s.buf[1:]
+			continue
+		}
+
+		userDataLen := int(s.buf[2]) - 5
+		if userDataLen < 0 {
+			s.buf = s.buf[1:]
+			continue
+		}
+
+		blocks := (userDataLen + dnp3BlockSize - 1) / dnp3BlockSize
+		frameLen := layers.MIN_HEADER_LENGTH + userDataLen + blocks*2
+
+		if len(s.buf) < frameLen {
+			return nil, false
+		}
+
+		frame := s.buf[:frameLen]
+		s.buf = s.buf[frameLen:]
+		return frame, true
+	}
+	return nil, false
+}
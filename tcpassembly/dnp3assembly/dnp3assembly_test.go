@@ -0,0 +1,113 @@
+// Copyright 2019, The GoPacket Authors, All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+//
+//******************************************************************************
+
+package dnp3assembly
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// buildDNP3LinkFrame assembles one on-the-wire DNP3 link frame with real
+// start field, length byte and addresses, junk CRCs (nextFrame never checks
+// them, and consume decodes with LenientCRC), and userData split into
+// 16-octet blocks each followed by its 2-octet CRC slot.
+func buildDNP3LinkFrame(dst, src int, userData []byte) []byte {
+	frame := make([]byte, 2)
+	binary.BigEndian.PutUint16(frame, layers.START_FIELD)
+	frame = append(frame, byte(5+len(userData))) // length byte
+	frame = append(frame, 0)                     // control byte, unused by these tests
+	dstBytes := make([]byte, 2)
+	binary.LittleEndian.PutUint16(dstBytes, uint16(dst))
+	srcBytes := make([]byte, 2)
+	binary.LittleEndian.PutUint16(srcBytes, uint16(src))
+	frame = append(frame, dstBytes...)
+	frame = append(frame, srcBytes...)
+	frame = append(frame, 0, 0) // header CRC, junk
+
+	for len(userData) > 0 {
+		n := dnp3BlockSize
+		if len(userData) < n {
+			n = len(userData)
+		}
+		frame = append(frame, userData[:n]...)
+		frame = append(frame, 0, 0) // block CRC, junk
+		userData = userData[n:]
+	}
+
+	return frame
+}
+
+// TestStreamNextFrameResyncsAndFrames checks that nextFrame skips leading
+// garbage a byte at a time until it finds the 0x0564 start delimiter, then
+// frames each link PDU using its length byte and block count, and reports
+// an incomplete trailing frame as not yet available.
+func TestStreamNextFrameResyncsAndFrames(t *testing.T) {
+	frame1 := buildDNP3LinkFrame(1, 2, []byte{0x01, 0x02, 0x03})
+	frame2 := buildDNP3LinkFrame(3, 4, make([]byte, 20)) // spans two 16-octet blocks
+	junk := []byte{0xAA, 0xBB, 0x00, 0xFF}
+
+	s := &Stream{buf: append(append(append([]byte{}, junk...), frame1...), frame2...)}
+
+	got, ok := s.nextFrame()
+	if !ok || !bytes.Equal(got, frame1) {
+		t.Fatalf("nextFrame() (frame1) = (% x, %v), want (% x, true)", got, ok, frame1)
+	}
+
+	got, ok = s.nextFrame()
+	if !ok || !bytes.Equal(got, frame2) {
+		t.Fatalf("nextFrame() (frame2) = (% x, %v), want (% x, true)", got, ok, frame2)
+	}
+
+	if _, ok := s.nextFrame(); ok {
+		t.Fatalf("nextFrame() on an empty buffer: ok=true, want false")
+	}
+
+	s.buf = frame1[:len(frame1)-1]
+	if frame, ok := s.nextFrame(); ok || frame != nil {
+		t.Fatalf("nextFrame() on a truncated frame = (% x, %v), want (nil, false)", frame, ok)
+	}
+}
+
+// TestStreamReassembledEmitsCompletedFragment feeds a single-segment DNP3
+// frame through Reassembled, split across two calls to mimic TCP segments
+// arriving separately, and checks the Factory's OnFragment callback fires
+// once reassembly completes.
+func TestStreamReassembledEmitsCompletedFragment(t *testing.T) {
+	const dst, src = 7, 9
+	appBytes := []byte{0x01, 0x02, 0x03}
+	transportByte := byte(0xC0) // First=1, Final=1, Sequence=0
+	frame := buildDNP3LinkFrame(dst, src, append([]byte{transportByte}, appBytes...))
+
+	var got struct {
+		src, dst int
+		fragment []byte
+	}
+	factory := NewFactory(func(src, dst int, fragment []byte) {
+		got.src, got.dst, got.fragment = src, dst, fragment
+	})
+	stream := factory.New(gopacket.Flow{}, gopacket.Flow{})
+
+	stream.Reassembled([]tcpassembly.Reassembly{{Bytes: frame[:5]}})
+	if got.fragment != nil {
+		t.Fatalf("fragment reported before the frame was fully buffered")
+	}
+	stream.Reassembled([]tcpassembly.Reassembly{{Bytes: frame[5:]}})
+
+	if got.src != src || got.dst != dst {
+		t.Errorf("OnFragment called with (%d, %d), want (%d, %d)", got.src, got.dst, src, dst)
+	}
+	if !bytes.Equal(got.fragment, appBytes) {
+		t.Errorf("fragment = % x, want % x", got.fragment, appBytes)
+	}
+}